@@ -4,6 +4,8 @@ import (
 	"context"
 	"errors"
 	"log"
+	"math"
+	"math/rand"
 	"os"
 	"time"
 
@@ -19,20 +21,70 @@ import (
 )
 
 var (
-	count          = flag.Int32P("count", "n", 1, "Number of instances to launch simultaneously")
-	interval       = flag.Duration("interval", 1*time.Second, "Time between instance launch attempts")
-	launchTemplate = flag.String("launch-template", "", "Launch template name")
+	count    = flag.Int32P("count", "n", 1, "Number of instances to launch simultaneously")
+	interval = flag.Duration("interval", 1*time.Second, "Time between instance launch attempts")
+
+	backoffStrategy = flag.String("backoff-strategy", "constant", `Backoff strategy between retries: "constant" or "exponential"`)
+	maxBackoff      = flag.Duration("max-backoff", 5*time.Minute, "Maximum delay between retries when --backoff-strategy=exponential")
+	jitter          = flag.Float64("jitter", 0, "Fraction (0.0-1.0) of equal-jitter randomization to apply to the exponential backoff delay")
+
+	retryOn         = flag.StringSlice("retry-on", nil, "Additional AWS error codes to retry, beyond the hardcoded defaults")
+	stopOn          = flag.StringSlice("stop-on", nil, "AWS error codes to never retry, overriding --retry-on and the hardcoded defaults")
+	retryThrottling = flag.Bool("retry-throttling", false, "Also retry standard AWS throttling and 5xx server errors")
 )
 
+// defaultRetryableCodes are retried even without --retry-on.
+var defaultRetryableCodes = []string{"MaxSpotInstanceCountExceeded", "SpotMaxPriceTooLow"}
+
+func errorCodeSet(codes ...string) map[string]bool {
+	set := make(map[string]bool, len(codes))
+	for _, code := range codes {
+		set[code] = true
+	}
+	return set
+}
+
+// backoffDelayer returns the retry.BackoffDelayerFunc selected by --backoff-strategy.
+func backoffDelayer() retry.BackoffDelayerFunc {
+	switch *backoffStrategy {
+	case "constant":
+		return func(attempt int, err error) (time.Duration, error) {
+			return *interval, nil
+		}
+	case "exponential":
+		return func(attempt int, err error) (time.Duration, error) {
+			// Compare as float64 before converting: for large attempt numbers the
+			// product overflows time.Duration.
+			delay := *maxBackoff
+			if backoff := float64(*interval) * math.Pow(2, float64(attempt-1)); backoff < float64(*maxBackoff) {
+				delay = time.Duration(backoff)
+			}
+			if *jitter > 0 {
+				delay = time.Duration(float64(delay) * (1 - *jitter + rand.Float64()*2*(*jitter)))
+				if delay > *maxBackoff {
+					delay = *maxBackoff
+				}
+			}
+			return delay, nil
+		}
+	default:
+		log.Fatalf("Unknown --backoff-strategy: %s", *backoffStrategy)
+		return nil
+	}
+}
+
 func InfiniteRetryer() aws.Retryer {
-	backoff := retry.BackoffDelayerFunc(func(attempt int, err error) (time.Duration, error) {
-		return *interval, nil
-	})
+	backoff := backoffDelayer()
+	retryOnSet := errorCodeSet(append(defaultRetryableCodes, *retryOn...)...)
+	stopOnSet := errorCodeSet(*stopOn...)
 	retryables := retry.IsErrorRetryableFunc(func(err error) aws.Ternary {
 		var apiErr smithy.APIError
 		if errors.As(err, &apiErr) {
-			switch apiErr.ErrorCode() {
-			case "MaxSpotInstanceCountExceeded", "SpotMaxPriceTooLow":
+			code := apiErr.ErrorCode()
+			switch {
+			case stopOnSet[code]:
+				return aws.FalseTernary
+			case retryOnSet[code]:
 				return aws.TrueTernary
 			}
 		}
@@ -42,26 +94,42 @@ func InfiniteRetryer() aws.Retryer {
 	retryer := retry.NewStandard(func(o *retry.StandardOptions) {
 		o.Backoff = backoff
 		o.RateLimiter = ratelimit.None
-		o.Retryables = append(o.Retryables, retryables)
+		// Start from just our own classification, not retry.NewStandard's seeded
+		// defaults, so --stop-on/--retry-on can override codes the SDK would
+		// otherwise already treat as retryable (e.g. throttling, 5xx), and so
+		// --retry-throttling actually gates whether those are retried at all.
+		o.Retryables = []retry.IsErrorRetryable{retryables}
+		if *retryThrottling {
+			o.Retryables = append(o.Retryables,
+				retry.RetryableConnectionError{},
+				retry.RetryableHTTPStatusCode{Codes: retry.DefaultRetryableHTTPStatusCodes},
+				retry.RetryableErrorCode{Codes: retry.DefaultThrottleErrorCodes},
+			)
+		}
 	})
 	// NOTE(https://github.com/aws/aws-sdk-go-v2/issues/3193): Using functional option (`o.MaxAttempts = 0`) sets MaxAttempts to the default
 	return retry.AddWithMaxAttempts(retryer, 0)
 }
 
-func RunInstances(ctx context.Context, client *ec2.Client, launchTemplate string) (*ec2.RunInstancesOutput, error) {
+func RunInstances(ctx context.Context, client *ec2.Client, launchTemplate string, maxCount int32) (*ec2.RunInstancesOutput, error) {
+	spec := &types.LaunchTemplateSpecification{
+		LaunchTemplateName: aws.String(launchTemplate),
+	}
+	if *launchTemplateVersion != "" {
+		spec.Version = aws.String(*launchTemplateVersion)
+	}
 	input := &ec2.RunInstancesInput{
-		LaunchTemplate: &types.LaunchTemplateSpecification{
-			LaunchTemplateName: aws.String(launchTemplate),
-		},
-		MinCount: aws.Int32(1),
-		MaxCount: count,
+		LaunchTemplate: spec,
+		MinCount:       aws.Int32(1),
+		MaxCount:       aws.Int32(maxCount),
 	}
 	return client.RunInstances(ctx, input)
 }
 
 func main() {
 	flag.Parse()
-	if *launchTemplate == "" {
+	templates := launchTemplates()
+	if len(templates) == 0 {
 		flag.Usage()
 		os.Exit(1)
 	}
@@ -73,16 +141,14 @@ func main() {
 	}
 	client := ec2.NewFromConfig(cfg)
 
-	for {
-		resp, err := RunInstances(ctx, client, *launchTemplate)
-		if err != nil {
-			log.Printf("Launch failed: %v", err)
-		} else {
-			for _, instance := range resp.Instances {
-				log.Printf("Launched %s instance in %s: %s", instance.InstanceType, *instance.Placement.AvailabilityZone, *instance.InstanceId)
-			}
-		}
+	sink, err := newOutputSink()
+	if err != nil {
+		log.Fatalf("Failed to open --output: %v", err)
+	}
+	defer sink.Close()
 
-		time.Sleep(*interval)
+	selector := newTemplateSelector(templates, *launchTemplateStrategy)
+	if err := newRunner(client, sink, selector).Run(ctx); err != nil {
+		log.Fatal(err)
 	}
 }
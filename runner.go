@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/smithy-go"
+
+	"golang.org/x/time/rate"
+
+	flag "github.com/spf13/pflag"
+)
+
+var (
+	targetCapacity = flag.Int32("target-capacity", 0, "Stop once this many instances have reached the running state (0 runs forever)")
+	targetTimeout  = flag.Duration("target-timeout", 0, "Give up waiting for --target-capacity after this long (0 waits forever)")
+
+	workers             = flag.Int("workers", 1, "Number of concurrent launch workers")
+	tokenBucketCapacity = flag.Int("token-bucket-capacity", 1, "Burst capacity of the shared rate limiter that paces launch attempts across all workers")
+)
+
+// runner drives the launch loop with a pool of --workers concurrent goroutines sharing a
+// single rate limiter, optionally stopping once --target-capacity instances have confirmed
+// running rather than looping forever.
+type runner struct {
+	client    *ec2.Client
+	sink      *outputSink
+	templates *templateSelector
+	limiter   *rate.Limiter
+
+	mu       sync.Mutex
+	attempt  int
+	reserved int32               // claimed by in-flight RunInstances calls, not yet resolved
+	pending  map[string]struct{} // launched, not yet confirmed running
+	running  map[string]struct{} // confirmed running, counts toward --target-capacity
+}
+
+func newRunner(client *ec2.Client, sink *outputSink, templates *templateSelector) *runner {
+	return &runner{
+		client:    client,
+		sink:      sink,
+		templates: templates,
+		limiter:   rate.NewLimiter(rate.Every(*interval), *tokenBucketCapacity),
+		pending:   make(map[string]struct{}),
+		running:   make(map[string]struct{}),
+	}
+}
+
+// Run starts --workers launch workers, each pulling a token from the shared rate limiter
+// before every RunInstances attempt except an immediate failover retry (see work), until
+// --target-capacity instances are running or --target-timeout elapses (or forever if
+// --target-capacity is unset).
+func (r *runner) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var deadline time.Time
+	if *targetTimeout > 0 {
+		deadline = time.Now().Add(*targetTimeout)
+	}
+
+	var (
+		stopOnce sync.Once
+		runErr   error
+	)
+	stop := func(err error) {
+		stopOnce.Do(func() {
+			runErr = err
+			cancel()
+		})
+	}
+
+	var wg sync.WaitGroup
+	if *targetCapacity > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.pollUntilTarget(ctx, deadline, stop)
+		}()
+	}
+
+	for i := 0; i < *workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.work(ctx)
+		}()
+	}
+
+	wg.Wait()
+	return runErr
+}
+
+// work runs one worker's launch loop until ctx is canceled. On a failover error (this
+// template's capacity is unavailable) it retries immediately with the next template,
+// skipping the rate limiter wait meant for pacing normal attempts.
+func (r *runner) work(ctx context.Context) {
+	skipWait := false
+	for {
+		if !skipWait {
+			if err := r.limiter.Wait(ctx); err != nil {
+				return
+			}
+		}
+		skipWait = false
+
+		maxCount, template, attempt, ok := r.nextAttempt()
+		if !ok {
+			continue
+		}
+
+		err := r.launch(ctx, template, maxCount, attempt)
+		r.mu.Lock()
+		r.reserved -= maxCount
+		skipWait = len(r.templates.templates) > 1 && isFailoverError(err)
+		r.templates.advance(err)
+		r.mu.Unlock()
+	}
+}
+
+// nextAttempt reserves the next attempt number, launch template, and instance count,
+// respecting any remaining --target-capacity headroom. The reserved count is added to
+// r.reserved while the lock is held, so concurrent workers can't both claim the same
+// headroom before either's RunInstances call returns; work releases it once the call
+// resolves. ok is false when a target capacity is already fully accounted for by
+// in-flight, pending, or running instances.
+func (r *runner) nextAttempt() (maxCount int32, template string, attempt int, ok bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	maxCount = *count
+	if *targetCapacity > 0 {
+		remaining := *targetCapacity - int32(len(r.running)) - int32(len(r.pending)) - r.reserved
+		if remaining < maxCount {
+			maxCount = remaining
+		}
+		if maxCount <= 0 {
+			return 0, "", 0, false
+		}
+	}
+
+	r.attempt++
+	r.reserved += maxCount
+	return maxCount, r.templates.current(), r.attempt, true
+}
+
+// launch makes one RunInstances attempt against the given template for up to maxCount
+// instances, logging and recording the outcome and tracking any launched instance IDs as
+// pending.
+func (r *runner) launch(ctx context.Context, template string, maxCount int32, attempt int) error {
+	resp, err := RunInstances(ctx, r.client, template, maxCount)
+	if err != nil {
+		record := LaunchRecord{
+			Time:                  time.Now(),
+			LaunchTemplate:        template,
+			LaunchTemplateVersion: *launchTemplateVersion,
+			Attempt:               attempt,
+		}
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) {
+			record.ErrorCode = apiErr.ErrorCode()
+			record.ErrorMessage = apiErr.ErrorMessage()
+		} else {
+			record.ErrorMessage = err.Error()
+		}
+		logRecord(record)
+		r.sink.write(record)
+		return err
+	}
+
+	requestID, _ := middleware.GetRequestIDMetadata(resp.ResultMetadata)
+	r.mu.Lock()
+	for _, instance := range resp.Instances {
+		r.pending[*instance.InstanceId] = struct{}{}
+	}
+	r.mu.Unlock()
+
+	for _, instance := range resp.Instances {
+		record := LaunchRecord{
+			Time:                  time.Now(),
+			LaunchTemplate:        template,
+			LaunchTemplateVersion: *launchTemplateVersion,
+			RequestID:             requestID,
+			Attempt:               attempt,
+			InstanceID:            *instance.InstanceId,
+			InstanceType:          string(instance.InstanceType),
+			AvailabilityZone:      *instance.Placement.AvailabilityZone,
+		}
+		logRecord(record)
+		r.sink.write(record)
+	}
+	return nil
+}
+
+// pollUntilTarget periodically refreshes pending instance status and calls stop once
+// --target-capacity instances are running, or once --target-timeout elapses.
+func (r *runner) pollUntilTarget(ctx context.Context, deadline time.Time, stop func(error)) {
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+
+	for {
+		if err := r.refreshRunning(ctx); err != nil {
+			log.Printf("Failed to refresh instance status: %v", err)
+		}
+
+		r.mu.Lock()
+		running := int32(len(r.running))
+		r.mu.Unlock()
+
+		if running >= *targetCapacity {
+			log.Printf("Reached target capacity of %d running instances", *targetCapacity)
+			stop(nil)
+			return
+		}
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			stop(fmt.Errorf("timed out after %s waiting for %d running instances (got %d)", *targetTimeout, *targetCapacity, running))
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// refreshRunning polls the state of pending instances, promoting the ones that reached
+// running and dropping the ones that terminated before we counted them.
+func (r *runner) refreshRunning(ctx context.Context) error {
+	r.mu.Lock()
+	ids := make([]string, 0, len(r.pending))
+	for id := range r.pending {
+		ids = append(ids, id)
+	}
+	r.mu.Unlock()
+
+	if len(ids) == 0 {
+		return nil
+	}
+
+	resp, err := r.client.DescribeInstances(ctx, &ec2.DescribeInstancesInput{InstanceIds: ids})
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, reservation := range resp.Reservations {
+		for _, instance := range reservation.Instances {
+			id := *instance.InstanceId
+			switch instance.State.Name {
+			case types.InstanceStateNameRunning:
+				delete(r.pending, id)
+				r.running[id] = struct{}{}
+			case types.InstanceStateNameTerminated, types.InstanceStateNameShuttingDown:
+				delete(r.pending, id)
+			}
+		}
+	}
+	return nil
+}
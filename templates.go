@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"math/rand"
+	"strings"
+
+	"github.com/aws/smithy-go"
+
+	flag "github.com/spf13/pflag"
+)
+
+var (
+	launchTemplateValues   = flag.StringArray("launch-template", nil, "Launch template name; repeat (or comma-separate) to enable failover across multiple templates")
+	launchTemplateVersion  = flag.String("launch-template-version", "", `Launch template version (defaults to the template's default version)`)
+	launchTemplateStrategy = flag.String("launch-template-strategy", "sticky-then-failover",
+		`Selection strategy across multiple --launch-template values: "round-robin", "sticky-then-failover", or "random"`)
+)
+
+// failoverErrorCodes are the errors that mean "this template's instance type/AZ is
+// unavailable", worth trying another launch template for rather than just backing off.
+var failoverErrorCodes = errorCodeSet("InsufficientInstanceCapacity", "Unsupported")
+
+func isFailoverError(err error) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && failoverErrorCodes[apiErr.ErrorCode()]
+}
+
+// launchTemplates flattens the repeated/comma-separated --launch-template values into an
+// ordered list of template names.
+func launchTemplates() []string {
+	var templates []string
+	for _, value := range *launchTemplateValues {
+		for _, t := range strings.Split(value, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				templates = append(templates, t)
+			}
+		}
+	}
+	return templates
+}
+
+// templateSelector picks which launch template to use for each attempt, rotating through
+// templates according to the configured --launch-template-strategy.
+type templateSelector struct {
+	templates []string
+	strategy  string
+	idx       int
+}
+
+func newTemplateSelector(templates []string, strategy string) *templateSelector {
+	switch strategy {
+	case "round-robin", "sticky-then-failover", "random":
+	default:
+		log.Fatalf("Unknown --launch-template-strategy: %s", strategy)
+	}
+	return &templateSelector{templates: templates, strategy: strategy}
+}
+
+// current returns the launch template to use for the next attempt.
+func (s *templateSelector) current() string {
+	return s.templates[s.idx%len(s.templates)]
+}
+
+// advance moves on to the next launch template per the configured strategy, given the
+// error (if any) from the attempt that just used the current template.
+func (s *templateSelector) advance(lastErr error) {
+	switch s.strategy {
+	case "round-robin":
+		s.idx++
+	case "random":
+		s.idx = rand.Intn(len(s.templates))
+	default: // sticky-then-failover
+		if isFailoverError(lastErr) {
+			s.idx++
+		}
+	}
+}